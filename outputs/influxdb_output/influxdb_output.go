@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
@@ -21,43 +24,88 @@ const (
 	defaultBatchSize         = 1000
 	defaultFlushTimer        = 10 * time.Second
 	defaultHealthCheckPeriod = 30 * time.Second
+	defaultWriteStrategy     = writeStrategyRoundRobin
+	defaultWorkers           = 1
+	defaultEventsBufferSize  = 1000
+	defaultMaxRetries        = 3
+	defaultRetryInterval     = time.Second
+	defaultMaxRetryInterval  = 30 * time.Second
+	defaultBufferMaxSize     = 100 << 20 // 100MiB
+)
 
-	numWorkers = 1
+const (
+	// writeStrategyRoundRobin spreads batches across all healthy endpoints.
+	writeStrategyRoundRobin = "round-robin"
+	// writeStrategyFailover always writes to the first healthy endpoint in config order.
+	writeStrategyFailover = "failover"
+	// writeStrategySticky keeps writing to the same endpoint until it goes unhealthy.
+	writeStrategySticky = "sticky"
 )
 
 func init() {
 	outputs.Register("influxdb", func() outputs.Output {
 		return &InfluxDBOutput{
-			Cfg:       &Config{},
-			eventChan: make(chan *collector.EventMsg),
-			reset:     make(chan struct{}),
-			startSig:  make(chan struct{}),
+			Cfg: &Config{},
 		}
 	})
 }
 
 type InfluxDBOutput struct {
-	Cfg       *Config
-	client    influxdb2.Client
-	metrics   []prometheus.Collector
-	logger    *log.Logger
-	cancelFn  context.CancelFunc
-	eventChan chan *collector.EventMsg
-	reset     chan struct{}
-	startSig  chan struct{}
-	wasup     bool
+	Cfg        *Config
+	endpoints  []*endpoint
+	processors []processor
+	metrics    *outputMetrics
+	logger     *log.Logger
+	cancelFn   context.CancelFunc
+	eventChan  chan *collector.EventMsg
+	rrIndex    uint32
 }
+
+// endpoint wraps a single InfluxDB target so that Init can keep one writer
+// (and its own health state) per configured URL.
+type endpoint struct {
+	url     string
+	w       writer
+	wal     *wal  // nil unless Cfg.BufferDir is set
+	healthy int32 // accessed atomically, 1 == healthy
+}
+
 type Config struct {
-	URL               string        `mapstructure:"url,omitempty"`
-	Org               string        `mapstructure:"org,omitempty"`
-	Bucket            string        `mapstructure:"bucket,omitempty"`
-	Token             string        `mapstructure:"token,omitempty"`
-	BatchSize         uint          `mapstructure:"batch_size,omitempty"`
-	FlushTimer        time.Duration `mapstructure:"flush_timer,omitempty"`
-	UseGzip           bool          `mapstructure:"use_gzip,omitempty"`
-	EnableTLS         bool          `mapstructure:"enable_tls,omitempty"`
-	HealthCheckPeriod time.Duration `mapstructure:"health_check_period,omitempty"`
-	Debug             bool          `mapstructure:"debug,omitempty"`
+	URL                string              `mapstructure:"url,omitempty"` // deprecated, use URLs
+	URLs               []string            `mapstructure:"urls,omitempty"`
+	WriteStrategy      string              `mapstructure:"write_strategy,omitempty"`
+	Version            string              `mapstructure:"version,omitempty"`          // v1 or v2, default v2
+	Org                string              `mapstructure:"org,omitempty"`              // v2 only
+	Bucket             string              `mapstructure:"bucket,omitempty"`           // v2 only
+	Token              string              `mapstructure:"token,omitempty"`            // v2 only
+	Username           string              `mapstructure:"username,omitempty"`         // v1 only
+	Password           string              `mapstructure:"password,omitempty"`         // v1 only
+	Database           string              `mapstructure:"database,omitempty"`         // v1 only
+	RetentionPolicy    string              `mapstructure:"retention_policy,omitempty"` // v1 only
+	Precision          string              `mapstructure:"precision,omitempty"`        // v1 only
+	Consistency        string              `mapstructure:"consistency,omitempty"`      // v1 only
+	BatchSize          uint                `mapstructure:"batch_size,omitempty"`
+	FlushTimer         time.Duration       `mapstructure:"flush_timer,omitempty"`
+	UseGzip            bool                `mapstructure:"use_gzip,omitempty"`
+	EnableTLS          bool                `mapstructure:"enable_tls,omitempty"`
+	CAFile             string              `mapstructure:"ca_file,omitempty"`
+	CertFile           string              `mapstructure:"cert_file,omitempty"`
+	KeyFile            string              `mapstructure:"key_file,omitempty"`
+	ServerName         string              `mapstructure:"server_name,omitempty"`
+	SkipVerify         bool                `mapstructure:"skip_verify,omitempty"`
+	HealthCheckPeriod  time.Duration       `mapstructure:"health_check_period,omitempty"`
+	Debug              bool                `mapstructure:"debug,omitempty"`
+	Workers            uint                `mapstructure:"workers,omitempty"`
+	EventsBufferSize   uint                `mapstructure:"events_buffer_size,omitempty"`
+	OverrideTimestamps bool                `mapstructure:"override_timestamps,omitempty"`
+	EnableMetrics      bool                `mapstructure:"enable_metrics,omitempty"`
+	BufferDir          string              `mapstructure:"buffer_dir,omitempty"`
+	BufferMaxSize      int64               `mapstructure:"buffer_max_size,omitempty"`
+	MaxRetries         int                 `mapstructure:"max_retries,omitempty"`
+	RetryInterval      time.Duration       `mapstructure:"retry_interval,omitempty"`
+	MaxRetryInterval   time.Duration       `mapstructure:"max_retry_interval,omitempty"`
+	RetryJitter        bool                `mapstructure:"retry_jitter,omitempty"`
+	Processors         []*ProcessorConfig  `mapstructure:"processors,omitempty"`
 }
 
 func (k *InfluxDBOutput) String() string {
@@ -84,8 +132,18 @@ func (i *InfluxDBOutput) Init(ctx context.Context, cfg map[string]interface{}, o
 		i.logger.Printf("influxdb output config decode failed: %v", err)
 		return err
 	}
-	if i.Cfg.URL == "" {
-		i.Cfg.URL = defaultURL
+	urls := i.Cfg.URLs
+	if len(urls) == 0 && i.Cfg.URL != "" {
+		urls = []string{i.Cfg.URL}
+	}
+	if len(urls) == 0 {
+		urls = []string{defaultURL}
+	}
+	if i.Cfg.WriteStrategy == "" {
+		i.Cfg.WriteStrategy = defaultWriteStrategy
+	}
+	if i.Cfg.Version == "" {
+		i.Cfg.Version = defaultVersion
 	}
 	if i.Cfg.BatchSize == 0 {
 		i.Cfg.BatchSize = defaultBatchSize
@@ -96,35 +154,82 @@ func (i *InfluxDBOutput) Init(ctx context.Context, cfg map[string]interface{}, o
 	if i.Cfg.HealthCheckPeriod == 0 {
 		i.Cfg.HealthCheckPeriod = defaultHealthCheckPeriod
 	}
+	if i.Cfg.Workers == 0 {
+		i.Cfg.Workers = defaultWorkers
+	}
+	if i.Cfg.EventsBufferSize == 0 {
+		i.Cfg.EventsBufferSize = defaultEventsBufferSize
+	}
+	if i.Cfg.MaxRetries == 0 {
+		i.Cfg.MaxRetries = defaultMaxRetries
+	}
+	if i.Cfg.RetryInterval == 0 {
+		i.Cfg.RetryInterval = defaultRetryInterval
+	}
+	if i.Cfg.MaxRetryInterval == 0 {
+		i.Cfg.MaxRetryInterval = defaultMaxRetryInterval
+	}
+	if i.Cfg.BufferMaxSize == 0 {
+		i.Cfg.BufferMaxSize = defaultBufferMaxSize
+	}
+	i.eventChan = make(chan *collector.EventMsg, i.Cfg.EventsBufferSize)
+	if i.Cfg.EnableMetrics {
+		i.metrics = newOutputMetrics(i.eventChan)
+	}
+	i.buildProcessors()
 
 	iopts := influxdb2.DefaultOptions().
 		SetUseGZip(i.Cfg.UseGzip).
 		SetBatchSize(i.Cfg.BatchSize).
 		SetFlushInterval(uint(i.Cfg.FlushTimer.Milliseconds()))
+	var tlsCfg *tls.Config
 	if i.Cfg.EnableTLS {
-		iopts.SetTLSConfig(&tls.Config{
-			InsecureSkipVerify: true,
-		})
+		if i.Cfg.SkipVerify {
+			i.logger.Printf("warning: skip_verify is set, TLS certificate verification is disabled")
+		}
+		tlsCfg, err = buildTLSConfig(i.Cfg.CAFile, i.Cfg.CertFile, i.Cfg.KeyFile, i.Cfg.ServerName, i.Cfg.SkipVerify)
+		if err != nil {
+			i.logger.Printf("failed to build TLS config: %v", err)
+			return err
+		}
+		iopts.SetTLSConfig(tlsCfg)
 	}
 	if i.Cfg.Debug {
 		iopts.SetLogLevel(3)
 	}
 	ctx, i.cancelFn = context.WithCancel(ctx)
-CRCLIENT:
-	i.client = influxdb2.NewClientWithOptions(i.Cfg.URL, i.Cfg.Token, iopts)
-	// start influx health check
-	err = i.health(ctx)
-	if err != nil {
-		log.Printf("failed to check influxdb health: %v", err)
-		time.Sleep(10 * time.Second)
-		goto CRCLIENT
+
+	i.endpoints = make([]*endpoint, 0, len(urls))
+	for idx, url := range urls {
+		w, err := newWriter(i.Cfg, url, iopts, tlsCfg)
+		if err != nil {
+			i.logger.Printf("failed to build writer for endpoint %q, skipping it: %v", url, err)
+			continue
+		}
+		ep := &endpoint{
+			url: url,
+			w:   w,
+		}
+		if i.Cfg.BufferDir != "" {
+			dir := filepath.Join(i.Cfg.BufferDir, fmt.Sprintf("endpoint-%d", idx))
+			wl, err := newWAL(dir, i.Cfg.BufferMaxSize, i.logger)
+			if err != nil {
+				i.logger.Printf("failed to open wal dir %q for endpoint %q: %v", dir, url, err)
+			} else {
+				ep.wal = wl
+				if i.metrics != nil {
+					ep.wal.setMetrics(i.metrics.walBytes.WithLabelValues(url), i.metrics.walReplayedTotal.WithLabelValues(url))
+				}
+			}
+		}
+		i.probe(ctx, ep, true)
+		go i.healthCheck(ctx, ep)
+		i.endpoints = append(i.endpoints, ep)
 	}
-	i.wasup = true
-	go i.healthCheck(ctx)
 	i.logger.Printf("initialized influxdb client: %s", i.String())
 
-	for k := 0; k < numWorkers; k++ {
-		go i.worker(ctx, k)
+	for k := uint(0); k < i.Cfg.Workers; k++ {
+		go i.worker(ctx, int(k))
 	}
 	go func() {
 		<-ctx.Done()
@@ -149,12 +254,21 @@ func (i *InfluxDBOutput) Write(ctx context.Context, rsp proto.Message, meta outp
 			return
 		}
 		for _, ev := range events {
+			for _, p := range i.processors {
+				p.apply(ev)
+			}
 			select {
 			case <-ctx.Done():
 				return
-			case <-i.reset:
-				return
 			case i.eventChan <- ev:
+				if i.metrics != nil {
+					i.metrics.eventsInFlight.Inc()
+				}
+			default:
+				if i.metrics != nil {
+					i.metrics.droppedTotal.Inc()
+				}
+				i.logger.Printf("event channel full, dropping event")
 			}
 		}
 	}
@@ -163,83 +277,201 @@ func (i *InfluxDBOutput) Write(ctx context.Context, rsp proto.Message, meta outp
 func (i *InfluxDBOutput) Close() error {
 	i.logger.Printf("closing client...")
 	i.cancelFn()
+	for _, ep := range i.endpoints {
+		ep.w.close()
+		if ep.wal != nil {
+			ep.wal.close()
+		}
+	}
 	i.logger.Printf("closed.")
 	return nil
 }
-func (i *InfluxDBOutput) Metrics() []prometheus.Collector { return i.metrics }
+func (i *InfluxDBOutput) Metrics() []prometheus.Collector { return i.metrics.collectors() }
 
-func (i *InfluxDBOutput) healthCheck(ctx context.Context) {
+// healthCheck periodically probes a single endpoint, flipping its healthy
+// flag so writers can steer traffic away from (and back to) it.
+func (i *InfluxDBOutput) healthCheck(ctx context.Context, ep *endpoint) {
 	ticker := time.NewTicker(i.Cfg.HealthCheckPeriod)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			i.health(ctx)
+			i.probe(ctx, ep, false)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (i *InfluxDBOutput) health(ctx context.Context) error {
-	res, err := i.client.Health(ctx)
+// probe runs a single health check against ep and updates its healthy flag
+// accordingly, logging only on state transitions. initial is set for the
+// one-time check Init runs before an endpoint ever takes traffic, so its
+// first successful check isn't logged as a "recovery" — it still kicks off
+// a WAL replay, since the directory may hold segments left over from a
+// previous run.
+func (i *InfluxDBOutput) probe(ctx context.Context, ep *endpoint, initial bool) {
+	err := ep.w.ping(ctx)
 	if err != nil {
-		i.logger.Printf("failed health check: %v", err)
-		if i.wasup {
-			close(i.reset)
-			i.reset = make(chan struct{})
+		if atomic.SwapInt32(&ep.healthy, 0) == 1 {
+			i.logger.Printf("endpoint %q failed health check, marking down: %v", ep.url, err)
 		}
-		return err
+		return
 	}
-	if res != nil {
-		b, err := json.Marshal(res)
-		if err != nil {
-			i.logger.Printf("failed to marshal health check result: %v", err)
-			i.logger.Printf("health check result: %+v", res)
-			if i.wasup {
-				close(i.reset)
-				i.reset = make(chan struct{})
+	if atomic.SwapInt32(&ep.healthy, 1) == 0 {
+		if !initial {
+			i.logger.Printf("endpoint %q recovered", ep.url)
+		}
+		if ep.wal != nil {
+			go i.replayWAL(ctx, ep)
+		}
+	}
+}
+
+// replayWAL drains ep's write-ahead log back through ep.w once the endpoint
+// comes back up. probe starts it in the background after marking ep healthy,
+// so workers may write live batches to ep while the replay is still running.
+func (i *InfluxDBOutput) replayWAL(ctx context.Context, ep *endpoint) {
+	if err := ep.wal.replay(ctx, ep.w.writeBatch); err != nil {
+		i.logger.Printf("endpoint %q: wal replay stopped early, will retry on next recovery: %v", ep.url, err)
+	}
+}
+
+// pickEndpoint selects the endpoint the next batch should be written to,
+// honoring Cfg.WriteStrategy. sticky is the endpoint the calling worker used
+// last time, if any, and is only reused while it stays healthy. It returns
+// nil when no endpoint is currently healthy.
+func (i *InfluxDBOutput) pickEndpoint(sticky *endpoint) *endpoint {
+	if i.Cfg.WriteStrategy == writeStrategySticky && sticky != nil && atomic.LoadInt32(&sticky.healthy) == 1 {
+		return sticky
+	}
+	switch i.Cfg.WriteStrategy {
+	case writeStrategyFailover, writeStrategySticky:
+		for _, ep := range i.endpoints {
+			if atomic.LoadInt32(&ep.healthy) == 1 {
+				return ep
+			}
+		}
+		return nil
+	default: // round-robin
+		n := len(i.endpoints)
+		for attempt := 0; attempt < n; attempt++ {
+			idx := int(atomic.AddUint32(&i.rrIndex, 1)-1) % n
+			if ep := i.endpoints[idx]; atomic.LoadInt32(&ep.healthy) == 1 {
+				return ep
 			}
-			return err
 		}
-		i.wasup = true
-		close(i.startSig)
-		i.startSig = make(chan struct{})
-		i.logger.Printf("health check result: %s", string(b))
 		return nil
 	}
-	i.wasup = true
-	close(i.startSig)
-	i.startSig = make(chan struct{})
-	i.logger.Print("health check result is nil")
-	return nil
 }
 
 func (i *InfluxDBOutput) worker(ctx context.Context, idx int) {
-	firstStart := true
-START:
-	if !firstStart {
-		i.logger.Printf("worker-%d waiting for client recovery", idx)
-		<-i.startSig
-	}
 	i.logger.Printf("starting worker-%d", idx)
-	writer := i.client.WriteAPI(i.Cfg.Org, i.Cfg.Bucket)
-	//defer writer.Flush()
+	var sticky *endpoint
+	batch := make([]*collector.EventMsg, 0, i.Cfg.BatchSize)
+	ticker := time.NewTicker(i.Cfg.FlushTimer)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if i.metrics != nil {
+			i.metrics.eventsInFlight.Sub(float64(len(batch)))
+		}
+		ep := i.pickEndpoint(sticky)
+		if ep == nil {
+			i.spoolOrDrop(idx, batch)
+			batch = batch[:0]
+			return
+		}
+		sticky = ep
+		if i.metrics != nil {
+			i.metrics.batchSize.Observe(float64(len(batch)))
+		}
+		i.writeBatch(ctx, ep, batch)
+		batch = batch[:0]
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			if ctx.Err() != nil {
 				i.logger.Printf("worker-%d err=%v", idx, ctx.Err())
 			}
+			flush()
 			i.logger.Printf("worker-%d terminating...", idx)
 			return
+		case <-ticker.C:
+			flush()
 		case ev := <-i.eventChan:
-			writer.WritePoint(influxdb2.NewPoint(ev.Name, ev.Tags, ev.Values, time.Unix(0, ev.Timestamp)))
-		case <-i.reset:
-			firstStart = false
-			i.logger.Printf("resetting worker-%d...", idx)
-			goto START
-		case err := <-writer.Errors():
-			i.logger.Printf("worker-%d write error: %v", idx, err)
+			if i.Cfg.OverrideTimestamps {
+				ev.Timestamp = time.Now().UnixNano()
+			}
+			batch = append(batch, ev)
+			if uint(len(batch)) >= i.Cfg.BatchSize {
+				flush()
+			}
 		}
 	}
 }
+
+// spoolOrDrop is used when no endpoint is currently healthy, which would
+// otherwise silently drop events produced during a sustained outage. It
+// spools batch to the first endpoint configured with a WAL that accepts it,
+// so it gets replayed once that endpoint recovers, falling back to dropping
+// it (and counting it in droppedTotal) only when no endpoint has a WAL
+// configured or every WAL write fails.
+func (i *InfluxDBOutput) spoolOrDrop(workerIdx int, batch []*collector.EventMsg) {
+	events := append([]*collector.EventMsg(nil), batch...)
+	for _, ep := range i.endpoints {
+		if ep.wal == nil {
+			continue
+		}
+		if err := ep.wal.write(events); err != nil {
+			i.logger.Printf("worker-%d: failed to spool batch to endpoint %q wal: %v", workerIdx, ep.url, err)
+			continue
+		}
+		return
+	}
+	i.logger.Printf("worker-%d: no healthy endpoint and no wal accepted the batch, dropping batch of %d events", workerIdx, len(batch))
+	if i.metrics != nil {
+		i.metrics.droppedTotal.Add(float64(len(batch)))
+	}
+}
+
+// writeBatch writes batch to ep, retrying with exponential backoff per
+// Cfg.MaxRetries/RetryInterval/MaxRetryInterval/RetryJitter. If every
+// attempt fails, the batch is handed to ep's WAL (when configured) instead
+// of being lost.
+func (i *InfluxDBOutput) writeBatch(ctx context.Context, ep *endpoint, batch []*collector.EventMsg) {
+	events := append([]*collector.EventMsg(nil), batch...)
+	rc := retryConfig{
+		maxRetries:  i.Cfg.MaxRetries,
+		interval:    i.Cfg.RetryInterval,
+		maxInterval: i.Cfg.MaxRetryInterval,
+		jitter:      i.Cfg.RetryJitter,
+	}
+	err := withRetry(ctx, rc, func(attempt int, err error) {
+		i.logger.Printf("endpoint %q: write attempt %d failed, retrying: %v", ep.url, attempt, err)
+		if i.metrics != nil {
+			i.metrics.retryTotal.WithLabelValues(ep.url).Inc()
+		}
+	}, func() error {
+		return ep.w.writeBatch(ctx, events)
+	})
+	if err != nil {
+		i.logger.Printf("endpoint %q: giving up on batch of %d events after retries: %v", ep.url, len(events), err)
+		if i.metrics != nil {
+			i.metrics.writeErrorsTotal.WithLabelValues(ep.url).Inc()
+		}
+		if ep.wal != nil {
+			if werr := ep.wal.write(events); werr != nil {
+				i.logger.Printf("endpoint %q: failed to spool batch to wal: %v", ep.url, werr)
+			}
+		}
+		return
+	}
+	if i.metrics != nil {
+		i.metrics.writeTotal.WithLabelValues(ep.url).Add(float64(len(events)))
+	}
+}
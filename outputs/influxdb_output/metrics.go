@@ -0,0 +1,94 @@
+package influxdb_output
+
+import (
+	"github.com/karimra/gnmic/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "gnmic_influxdb_output"
+
+// outputMetrics bundles the Prometheus collectors exposed through
+// InfluxDBOutput.Metrics() when Cfg.EnableMetrics is set. It stays nil
+// otherwise so the hot write path never pays for label lookups.
+type outputMetrics struct {
+	writeTotal       *prometheus.CounterVec
+	writeErrorsTotal *prometheus.CounterVec
+	retryTotal       *prometheus.CounterVec
+	droppedTotal     prometheus.Counter
+	batchSize        prometheus.Histogram
+	eventsInFlight   prometheus.Gauge
+	channelOccupancy prometheus.Collector
+	walBytes         *prometheus.GaugeVec
+	walReplayedTotal *prometheus.CounterVec
+}
+
+// newOutputMetrics builds the collectors and wires channelOccupancy to
+// report the live depth of eventChan via a GaugeFunc.
+func newOutputMetrics(eventChan chan *collector.EventMsg) *outputMetrics {
+	return &outputMetrics{
+		writeTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "write_total",
+			Help:      "number of points successfully written, per endpoint",
+		}, []string{"endpoint"}),
+		writeErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "write_errors_total",
+			Help:      "number of batches that failed to write after all retries, per endpoint",
+		}, []string{"endpoint"}),
+		retryTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "retry_total",
+			Help:      "number of write retries attempted, per endpoint",
+		}, []string{"endpoint"}),
+		droppedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_events_total",
+			Help:      "number of events dropped, either the event channel was full or no endpoint was healthy",
+		}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "batch_size",
+			Help:      "number of events in each batch handed to a writer",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		eventsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_in_flight",
+			Help:      "number of events accepted by Write but not yet handed to a writer",
+		}),
+		channelOccupancy: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "event_channel_occupancy",
+			Help:      "current number of events buffered in the event channel",
+		}, func() float64 { return float64(len(eventChan)) }),
+		walBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "wal_bytes",
+			Help:      "current on-disk size of the write-ahead log, per endpoint",
+		}, []string{"endpoint"}),
+		walReplayedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "wal_replayed_total",
+			Help:      "number of events replayed from the write-ahead log, per endpoint",
+		}, []string{"endpoint"}),
+	}
+}
+
+// collectors returns the metrics as a flat slice for Metrics().
+func (m *outputMetrics) collectors() []prometheus.Collector {
+	if m == nil {
+		return nil
+	}
+	return []prometheus.Collector{
+		m.writeTotal,
+		m.writeErrorsTotal,
+		m.retryTotal,
+		m.droppedTotal,
+		m.batchSize,
+		m.eventsInFlight,
+		m.channelOccupancy,
+		m.walBytes,
+		m.walReplayedTotal,
+	}
+}
@@ -0,0 +1,250 @@
+package influxdb_output
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/karimra/gnmic/collector"
+)
+
+// ProcessorConfig is a single entry in Config.Processors. Exactly one field
+// should be set; it names the processor type to build, mirroring the
+// tag/label manipulation processors found in Telegraf-family outputs.
+type ProcessorConfig struct {
+	AddTag              *AddTagConfig              `mapstructure:"add-tag,omitempty"`
+	RenameTag           *RenameTagConfig           `mapstructure:"rename-tag,omitempty"`
+	DropTag             *DropTagConfig             `mapstructure:"drop-tag,omitempty"`
+	RenameValue         *RenameValueConfig         `mapstructure:"rename-value,omitempty"`
+	DropValue           *DropValueConfig           `mapstructure:"drop-value,omitempty"`
+	Convert             *ConvertConfig             `mapstructure:"convert,omitempty"`
+	MeasurementOverride *MeasurementOverrideConfig `mapstructure:"measurement-override,omitempty"`
+}
+
+// AddTagConfig sets Tags[Key] to Value on every event. Value may be a Go
+// template (e.g. "{{ index .Tags \"source\" }}") evaluated against the
+// *collector.EventMsg, or a plain static string.
+type AddTagConfig struct {
+	Key   string `mapstructure:"key,omitempty"`
+	Value string `mapstructure:"value,omitempty"`
+}
+
+// RenameTagConfig moves Tags[From] to Tags[To], leaving the event untouched
+// if From isn't present.
+type RenameTagConfig struct {
+	From string `mapstructure:"from,omitempty"`
+	To   string `mapstructure:"to,omitempty"`
+}
+
+// DropTagConfig removes every tag whose key matches Regex.
+type DropTagConfig struct {
+	Regex string `mapstructure:"regex,omitempty"`
+}
+
+// RenameValueConfig moves Values[From] to Values[To].
+type RenameValueConfig struct {
+	From string `mapstructure:"from,omitempty"`
+	To   string `mapstructure:"to,omitempty"`
+}
+
+// DropValueConfig removes every field whose key matches Regex.
+type DropValueConfig struct {
+	Regex string `mapstructure:"regex,omitempty"`
+}
+
+// ConvertConfig parses Values[Key], when it's a string, into To ("int",
+// "float" or "bool"), leaving it untouched if the parse fails.
+type ConvertConfig struct {
+	Key string `mapstructure:"key,omitempty"`
+	To  string `mapstructure:"to,omitempty"`
+}
+
+// MeasurementOverrideConfig replaces ev.Name with the result of applying
+// Regex's ReplaceAllString to it using Template (so Template can reference
+// capture groups as $1, ${name}, etc.), for names that match Regex.
+type MeasurementOverrideConfig struct {
+	Regex    string `mapstructure:"regex,omitempty"`
+	Template string `mapstructure:"template,omitempty"`
+}
+
+// processor is the common shape every enrichment rule implements.
+type processor interface {
+	apply(ev *collector.EventMsg)
+}
+
+// buildProcessors compiles Config.Processors in order, skipping (and
+// logging) any entry that fails to build rather than aborting Init for a
+// single bad rule.
+func (i *InfluxDBOutput) buildProcessors() {
+	for idx, pc := range i.Cfg.Processors {
+		p, err := newProcessor(pc)
+		if err != nil {
+			i.logger.Printf("skipping processor #%d: %v", idx, err)
+			continue
+		}
+		i.processors = append(i.processors, p)
+	}
+}
+
+func newProcessor(pc *ProcessorConfig) (processor, error) {
+	switch {
+	case pc.AddTag != nil:
+		return newAddTagProcessor(pc.AddTag)
+	case pc.RenameTag != nil:
+		return &renameTagProcessor{from: pc.RenameTag.From, to: pc.RenameTag.To}, nil
+	case pc.DropTag != nil:
+		re, err := regexp.Compile(pc.DropTag.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("drop-tag: %w", err)
+		}
+		return &dropTagProcessor{regex: re}, nil
+	case pc.RenameValue != nil:
+		return &renameValueProcessor{from: pc.RenameValue.From, to: pc.RenameValue.To}, nil
+	case pc.DropValue != nil:
+		re, err := regexp.Compile(pc.DropValue.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("drop-value: %w", err)
+		}
+		return &dropValueProcessor{regex: re}, nil
+	case pc.Convert != nil:
+		return &convertProcessor{key: pc.Convert.Key, to: pc.Convert.To}, nil
+	case pc.MeasurementOverride != nil:
+		re, err := regexp.Compile(pc.MeasurementOverride.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("measurement-override: %w", err)
+		}
+		return &measurementOverrideProcessor{regex: re, template: pc.MeasurementOverride.Template}, nil
+	default:
+		return nil, fmt.Errorf("empty processor entry")
+	}
+}
+
+type addTagProcessor struct {
+	key   string
+	value string
+	tmpl  *template.Template // nil when value is a static string
+}
+
+func newAddTagProcessor(cfg *AddTagConfig) (*addTagProcessor, error) {
+	p := &addTagProcessor{key: cfg.Key, value: cfg.Value}
+	if strings.Contains(cfg.Value, "{{") {
+		tmpl, err := template.New("add-tag").Parse(cfg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("add-tag: %w", err)
+		}
+		p.tmpl = tmpl
+	}
+	return p, nil
+}
+
+func (p *addTagProcessor) apply(ev *collector.EventMsg) {
+	if ev.Tags == nil {
+		ev.Tags = make(map[string]string)
+	}
+	if p.tmpl == nil {
+		ev.Tags[p.key] = p.value
+		return
+	}
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, ev); err != nil {
+		return
+	}
+	ev.Tags[p.key] = buf.String()
+}
+
+type renameTagProcessor struct {
+	from string
+	to   string
+}
+
+func (p *renameTagProcessor) apply(ev *collector.EventMsg) {
+	v, ok := ev.Tags[p.from]
+	if !ok {
+		return
+	}
+	delete(ev.Tags, p.from)
+	ev.Tags[p.to] = v
+}
+
+type dropTagProcessor struct {
+	regex *regexp.Regexp
+}
+
+func (p *dropTagProcessor) apply(ev *collector.EventMsg) {
+	for k := range ev.Tags {
+		if p.regex.MatchString(k) {
+			delete(ev.Tags, k)
+		}
+	}
+}
+
+type renameValueProcessor struct {
+	from string
+	to   string
+}
+
+func (p *renameValueProcessor) apply(ev *collector.EventMsg) {
+	v, ok := ev.Values[p.from]
+	if !ok {
+		return
+	}
+	delete(ev.Values, p.from)
+	ev.Values[p.to] = v
+}
+
+type dropValueProcessor struct {
+	regex *regexp.Regexp
+}
+
+func (p *dropValueProcessor) apply(ev *collector.EventMsg) {
+	for k := range ev.Values {
+		if p.regex.MatchString(k) {
+			delete(ev.Values, k)
+		}
+	}
+}
+
+type convertProcessor struct {
+	key string
+	to  string
+}
+
+func (p *convertProcessor) apply(ev *collector.EventMsg) {
+	v, ok := ev.Values[p.key]
+	if !ok {
+		return
+	}
+	s, ok := v.(string)
+	if !ok {
+		return
+	}
+	switch p.to {
+	case "int":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ev.Values[p.key] = n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			ev.Values[p.key] = f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(s); err == nil {
+			ev.Values[p.key] = b
+		}
+	}
+}
+
+type measurementOverrideProcessor struct {
+	regex    *regexp.Regexp
+	template string
+}
+
+func (p *measurementOverrideProcessor) apply(ev *collector.EventMsg) {
+	if !p.regex.MatchString(ev.Name) {
+		return
+	}
+	ev.Name = p.regex.ReplaceAllString(ev.Name, p.template)
+}
@@ -0,0 +1,186 @@
+package influxdb_output
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/karimra/gnmic/collector"
+)
+
+func TestAddTagProcessor(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  *AddTagConfig
+		ev   *collector.EventMsg
+		want string
+	}{
+		{
+			name: "static value",
+			cfg:  &AddTagConfig{Key: "env", Value: "prod"},
+			ev:   &collector.EventMsg{Tags: map[string]string{}},
+			want: "prod",
+		},
+		{
+			name: "templated value",
+			cfg:  &AddTagConfig{Key: "source", Value: "{{ index .Tags \"host\" }}"},
+			ev:   &collector.EventMsg{Tags: map[string]string{"host": "router1"}},
+			want: "router1",
+		},
+		{
+			name: "nil tags map",
+			cfg:  &AddTagConfig{Key: "env", Value: "prod"},
+			ev:   &collector.EventMsg{},
+			want: "prod",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := newAddTagProcessor(tc.cfg)
+			if err != nil {
+				t.Fatalf("newAddTagProcessor: %v", err)
+			}
+			p.apply(tc.ev)
+			if got := tc.ev.Tags[tc.cfg.Key]; got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenameTagProcessor(t *testing.T) {
+	p := &renameTagProcessor{from: "old", to: "new"}
+
+	ev := &collector.EventMsg{Tags: map[string]string{"old": "v"}}
+	p.apply(ev)
+	if _, ok := ev.Tags["old"]; ok {
+		t.Fatal("expected \"old\" tag to be removed")
+	}
+	if ev.Tags["new"] != "v" {
+		t.Fatalf("expected \"new\" tag to be %q, got %q", "v", ev.Tags["new"])
+	}
+
+	// missing source key is a no-op
+	ev2 := &collector.EventMsg{Tags: map[string]string{"other": "v"}}
+	p.apply(ev2)
+	if len(ev2.Tags) != 1 {
+		t.Fatalf("expected no change when \"from\" key is absent, got %v", ev2.Tags)
+	}
+}
+
+func TestDropTagProcessor(t *testing.T) {
+	p, err := newProcessor(&ProcessorConfig{DropTag: &DropTagConfig{Regex: "^internal_"}})
+	if err != nil {
+		t.Fatalf("newProcessor: %v", err)
+	}
+	ev := &collector.EventMsg{Tags: map[string]string{
+		"internal_id": "1",
+		"region":      "us-east",
+	}}
+	p.apply(ev)
+	if _, ok := ev.Tags["internal_id"]; ok {
+		t.Fatal("expected tag matching the regex to be dropped")
+	}
+	if ev.Tags["region"] != "us-east" {
+		t.Fatal("expected non-matching tag to be kept")
+	}
+}
+
+func TestRenameValueProcessor(t *testing.T) {
+	p := &renameValueProcessor{from: "old", to: "new"}
+	ev := &collector.EventMsg{Values: map[string]interface{}{"old": 42}}
+	p.apply(ev)
+	if _, ok := ev.Values["old"]; ok {
+		t.Fatal("expected \"old\" value to be removed")
+	}
+	if ev.Values["new"] != 42 {
+		t.Fatalf("expected \"new\" value to be 42, got %v", ev.Values["new"])
+	}
+}
+
+func TestDropValueProcessor(t *testing.T) {
+	p, err := newProcessor(&ProcessorConfig{DropValue: &DropValueConfig{Regex: "^debug_"}})
+	if err != nil {
+		t.Fatalf("newProcessor: %v", err)
+	}
+	ev := &collector.EventMsg{Values: map[string]interface{}{
+		"debug_raw": "x",
+		"cpu":       1.0,
+	}}
+	p.apply(ev)
+	if _, ok := ev.Values["debug_raw"]; ok {
+		t.Fatal("expected value matching the regex to be dropped")
+	}
+	if ev.Values["cpu"] != 1.0 {
+		t.Fatal("expected non-matching value to be kept")
+	}
+}
+
+func TestConvertProcessor(t *testing.T) {
+	cases := []struct {
+		name string
+		to   string
+		in   interface{}
+		want interface{}
+	}{
+		{name: "string to int", to: "int", in: "42", want: int64(42)},
+		{name: "string to float", to: "float", in: "3.5", want: 3.5},
+		{name: "string to bool", to: "bool", in: "true", want: true},
+		{name: "unparseable left untouched", to: "int", in: "not-a-number", want: "not-a-number"},
+		{name: "non-string left untouched", to: "int", in: 42, want: 42},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &convertProcessor{key: "v", to: tc.to}
+			ev := &collector.EventMsg{Values: map[string]interface{}{"v": tc.in}}
+			p.apply(ev)
+			if ev.Values["v"] != tc.want {
+				t.Fatalf("got %v (%T), want %v (%T)", ev.Values["v"], ev.Values["v"], tc.want, tc.want)
+			}
+		})
+	}
+}
+
+func TestMeasurementOverrideProcessor(t *testing.T) {
+	p, err := newProcessor(&ProcessorConfig{MeasurementOverride: &MeasurementOverrideConfig{
+		Regex:    `^interfaces/interface\[name=(.+)\]$`,
+		Template: "if_$1",
+	}})
+	if err != nil {
+		t.Fatalf("newProcessor: %v", err)
+	}
+	ev := &collector.EventMsg{Name: "interfaces/interface[name=eth0]"}
+	p.apply(ev)
+	if ev.Name != "if_eth0" {
+		t.Fatalf("got %q, want %q", ev.Name, "if_eth0")
+	}
+
+	// non-matching names are left alone
+	ev2 := &collector.EventMsg{Name: "other"}
+	p.apply(ev2)
+	if ev2.Name != "other" {
+		t.Fatalf("expected non-matching name to be left untouched, got %q", ev2.Name)
+	}
+}
+
+func TestNewProcessor_EmptyEntryErrors(t *testing.T) {
+	if _, err := newProcessor(&ProcessorConfig{}); err == nil {
+		t.Fatal("expected an error for an empty processor entry")
+	}
+}
+
+func TestBuildProcessors_SkipsInvalidEntries(t *testing.T) {
+	i := &InfluxDBOutput{
+		Cfg: &Config{
+			Processors: []*ProcessorConfig{
+				{DropTag: &DropTagConfig{Regex: "("}}, // invalid regex
+				{AddTag: &AddTagConfig{Key: "env", Value: "prod"}},
+			},
+		},
+		logger: log.New(os.Stderr, "", 0),
+	}
+	i.buildProcessors()
+	if len(i.processors) != 1 {
+		t.Fatalf("expected the invalid entry to be skipped, got %d processors", len(i.processors))
+	}
+}
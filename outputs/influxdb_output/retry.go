@@ -0,0 +1,51 @@
+package influxdb_output
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// retryConfig groups the exponential-backoff knobs shared by every
+// endpoint's write path.
+type retryConfig struct {
+	maxRetries  int
+	interval    time.Duration
+	maxInterval time.Duration
+	jitter      bool
+}
+
+// withRetry calls fn until it succeeds, ctx is done, or maxRetries further
+// attempts have been made (maxRetries == 0 means fn runs once, no retries).
+// Each retry backs off exponentially from interval up to maxInterval; when
+// jitter is set the actual sleep is a random duration in [0, wait).
+// onRetry, if non-nil, is called before each wait with the 1-based retry
+// number and the error that triggered it.
+func withRetry(ctx context.Context, cfg retryConfig, onRetry func(attempt int, err error), fn func() error) error {
+	wait := cfg.interval
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= cfg.maxRetries {
+			return err
+		}
+		if onRetry != nil {
+			onRetry(attempt+1, err)
+		}
+		sleep := wait
+		if cfg.jitter && wait > 0 {
+			sleep = time.Duration(rand.Int63n(int64(wait)))
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(sleep):
+		}
+		wait *= 2
+		if cfg.maxInterval > 0 && wait > cfg.maxInterval {
+			wait = cfg.maxInterval
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package influxdb_output
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithRetry_SucceedsWithoutRetrying(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), retryConfig{maxRetries: 3, interval: time.Millisecond}, nil, func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected fn to be called once, got %d", calls)
+	}
+}
+
+func TestWithRetry_RetriesUntilSuccess(t *testing.T) {
+	calls := 0
+	retries := 0
+	err := withRetry(context.Background(), retryConfig{maxRetries: 5, interval: time.Millisecond}, func(attempt int, err error) {
+		retries++
+	}, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected fn to be called 3 times, got %d", calls)
+	}
+	if retries != 2 {
+		t.Fatalf("expected onRetry to fire 2 times, got %d", retries)
+	}
+}
+
+func TestWithRetry_GivesUpAfterMaxRetries(t *testing.T) {
+	calls := 0
+	wantErr := errors.New("permanent failure")
+	err := withRetry(context.Background(), retryConfig{maxRetries: 2, interval: time.Millisecond}, nil, func() error {
+		calls++
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+	if calls != 3 { // initial attempt + 2 retries
+		t.Fatalf("expected fn to be called 3 times, got %d", calls)
+	}
+}
+
+func TestWithRetry_NoRetriesMeansSingleAttempt(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), retryConfig{maxRetries: 0, interval: time.Millisecond}, nil, func() error {
+		calls++
+		return errors.New("fails")
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 {
+		t.Fatalf("expected fn to be called once, got %d", calls)
+	}
+}
+
+func TestWithRetry_StopsOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- withRetry(ctx, retryConfig{maxRetries: 100, interval: time.Second}, nil, func() error {
+			calls++
+			if calls == 1 {
+				cancel()
+			}
+			return errors.New("still failing")
+		})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error after context cancellation")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("withRetry did not return after context cancellation")
+	}
+	if calls != 1 {
+		t.Fatalf("expected fn to be called once before the cancel was observed, got %d", calls)
+	}
+}
+
+func TestWithRetry_BackoffCappedAtMaxInterval(t *testing.T) {
+	cfg := retryConfig{
+		maxRetries:  4,
+		interval:    10 * time.Millisecond,
+		maxInterval: 15 * time.Millisecond,
+	}
+	start := time.Now()
+	calls := 0
+	_ = withRetry(context.Background(), cfg, nil, func() error {
+		calls++
+		return errors.New("fails")
+	})
+	elapsed := time.Since(start)
+	// Uncapped backoff (10+20+40+80ms) would take 150ms; capped at 15ms per
+	// retry it should finish well under that.
+	if elapsed > 100*time.Millisecond {
+		t.Fatalf("expected backoff to be capped at maxInterval, took %v", elapsed)
+	}
+}
@@ -0,0 +1,39 @@
+package influxdb_output
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// buildTLSConfig loads a *tls.Config from CA bundle / client keypair files.
+// It only touches the filesystem and the standard library, so it has no
+// dependency on this package — it's written to be lifted into a shared
+// helper other gnmic outputs can call once they grow the same CAFile/
+// CertFile/KeyFile/ServerName/SkipVerify knobs.
+func buildTLSConfig(caFile, certFile, keyFile, serverName string, skipVerify bool) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		ServerName:         serverName,
+	}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %q: %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse CA file %q", caFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client keypair: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
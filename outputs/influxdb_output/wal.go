@@ -0,0 +1,237 @@
+package influxdb_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// walSegmentMaxBytes is the size at which the currently open WAL segment is
+// rotated into a new file.
+const walSegmentMaxBytes = 8 << 20 // 8MiB
+
+// walPoint is the on-disk, JSON line-delimited representation of a
+// collector.EventMsg spooled to the WAL.
+type walPoint struct {
+	Name      string                 `json:"name"`
+	Tags      map[string]string      `json:"tags"`
+	Values    map[string]interface{} `json:"values"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+func eventToWALPoint(ev *collector.EventMsg) walPoint {
+	return walPoint{Name: ev.Name, Tags: ev.Tags, Values: ev.Values, Timestamp: ev.Timestamp}
+}
+
+func (p walPoint) toEvent() *collector.EventMsg {
+	return &collector.EventMsg{Name: p.Name, Tags: p.Tags, Values: p.Values, Timestamp: p.Timestamp}
+}
+
+// wal is a size-capped, segmented write-ahead log used to hold batches that
+// couldn't be written to an endpoint after exhausting retries. Each write
+// fsyncs the segment it lands in; once the WAL's total size exceeds
+// maxBytes, the oldest segments are evicted first.
+type wal struct {
+	dir      string
+	maxBytes int64
+	logger   *log.Logger
+
+	mu        sync.Mutex
+	segments  []string // paths, oldest first
+	curFile   *os.File
+	curSize   int64
+	totalSize int64
+
+	bytesGauge      prometheus.Gauge
+	replayedCounter prometheus.Counter
+}
+
+// newWAL opens (or creates) dir and indexes any segments left over from a
+// previous run so they get replayed/evicted like ones written this run.
+func newWAL(dir string, maxBytes int64, logger *log.Logger) (*wal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &wal{dir: dir, maxBytes: maxBytes, logger: logger}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".wal" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		w.segments = append(w.segments, path)
+		w.totalSize += info.Size()
+	}
+	return w, nil
+}
+
+// setMetrics wires the WAL's Prometheus collectors; called once after
+// construction when Cfg.EnableMetrics is set.
+func (w *wal) setMetrics(bytesGauge prometheus.Gauge, replayedCounter prometheus.Counter) {
+	w.bytesGauge = bytesGauge
+	w.replayedCounter = replayedCounter
+	if bytesGauge != nil {
+		bytesGauge.Set(float64(w.totalSize))
+	}
+}
+
+// write appends events to the current segment, rotating to a fresh one once
+// the current segment grows past walSegmentMaxBytes, and evicts the oldest
+// segments first if the WAL would otherwise exceed maxBytes.
+func (w *wal) write(events []*collector.EventMsg) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.curFile == nil || w.curSize >= walSegmentMaxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ev := range events {
+		if err := enc.Encode(eventToWALPoint(ev)); err != nil {
+			return err
+		}
+	}
+	n, err := w.curFile.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := w.curFile.Sync(); err != nil {
+		return err
+	}
+	w.curSize += int64(n)
+	w.totalSize += int64(n)
+	w.evictOldestLocked()
+	if w.bytesGauge != nil {
+		w.bytesGauge.Set(float64(w.totalSize))
+	}
+	return nil
+}
+
+// rotate must be called with w.mu held.
+func (w *wal) rotate() error {
+	if w.curFile != nil {
+		w.curFile.Close()
+	}
+	path := filepath.Join(w.dir, fmt.Sprintf("%d.wal", time.Now().UnixNano()))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w.curFile = f
+	w.curSize = 0
+	w.segments = append(w.segments, path)
+	return nil
+}
+
+// evictOldestLocked drops the oldest segments until the WAL fits within
+// maxBytes, but never evicts the segment currently being written to.
+// Must be called with w.mu held.
+func (w *wal) evictOldestLocked() {
+	for w.maxBytes > 0 && w.totalSize > w.maxBytes && len(w.segments) > 1 {
+		oldest := w.segments[0]
+		if info, err := os.Stat(oldest); err == nil {
+			w.totalSize -= info.Size()
+		}
+		if err := os.Remove(oldest); err != nil {
+			w.logger.Printf("failed to evict wal segment %q: %v", oldest, err)
+		}
+		w.segments = w.segments[1:]
+	}
+}
+
+// replay drains every WAL segment, oldest first, handing each one's events
+// to write. A segment that holds events is only removed once write succeeds
+// for it; segments that can't be read, or hold no events, are removed
+// without being written. On the first write failure, that segment and
+// everything after it are put back so the next recovery can retry them.
+func (w *wal) replay(ctx context.Context, write func(ctx context.Context, events []*collector.EventMsg) error) error {
+	w.mu.Lock()
+	if w.curFile != nil {
+		w.curFile.Close()
+		w.curFile = nil
+		w.curSize = 0
+	}
+	segments := w.segments
+	w.segments = nil
+	w.mu.Unlock()
+
+	for idx, path := range segments {
+		events, err := readWALSegment(path)
+		if err != nil {
+			w.logger.Printf("failed to read wal segment %q, dropping it: %v", path, err)
+			w.removeSegment(path)
+			continue
+		}
+		if len(events) == 0 {
+			w.removeSegment(path)
+			continue
+		}
+		if err := write(ctx, events); err != nil {
+			w.mu.Lock()
+			w.segments = append(append([]string{}, segments[idx:]...), w.segments...)
+			w.mu.Unlock()
+			return err
+		}
+		if w.replayedCounter != nil {
+			w.replayedCounter.Add(float64(len(events)))
+		}
+		w.removeSegment(path)
+	}
+	return nil
+}
+
+func (w *wal) removeSegment(path string) {
+	w.mu.Lock()
+	if info, err := os.Stat(path); err == nil {
+		w.totalSize -= info.Size()
+	}
+	if w.bytesGauge != nil {
+		w.bytesGauge.Set(float64(w.totalSize))
+	}
+	w.mu.Unlock()
+	os.Remove(path)
+}
+
+func (w *wal) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.curFile != nil {
+		w.curFile.Close()
+	}
+}
+
+func readWALSegment(path string) ([]*collector.EventMsg, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []*collector.EventMsg
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var p walPoint
+		if err := dec.Decode(&p); err != nil {
+			return events, err
+		}
+		events = append(events, p.toEvent())
+	}
+	return events, nil
+}
@@ -0,0 +1,153 @@
+package influxdb_output
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karimra/gnmic/collector"
+)
+
+func newTestWAL(t *testing.T, maxBytes int64) *wal {
+	t.Helper()
+	w, err := newWAL(t.TempDir(), maxBytes, log.New(os.Stderr, "", 0))
+	if err != nil {
+		t.Fatalf("newWAL: %v", err)
+	}
+	return w
+}
+
+func testEvents(n int) []*collector.EventMsg {
+	events := make([]*collector.EventMsg, n)
+	for i := range events {
+		events[i] = &collector.EventMsg{
+			Name:      "meas",
+			Tags:      map[string]string{"idx": "t"},
+			Values:    map[string]interface{}{"v": i},
+			Timestamp: int64(i),
+		}
+	}
+	return events
+}
+
+func TestWAL_WriteAndReplay(t *testing.T) {
+	w := newTestWAL(t, 0)
+	if err := w.write(testEvents(3)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var replayed []*collector.EventMsg
+	err := w.replay(context.Background(), func(ctx context.Context, events []*collector.EventMsg) error {
+		replayed = append(replayed, events...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(replayed) != 3 {
+		t.Fatalf("expected 3 replayed events, got %d", len(replayed))
+	}
+	if len(w.segments) != 0 {
+		t.Fatalf("expected segments to be drained after a successful replay, got %d", len(w.segments))
+	}
+}
+
+func TestWAL_ReplayRequeuesOnFailure(t *testing.T) {
+	w := newTestWAL(t, 0)
+	if err := w.write(testEvents(1)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	w.mu.Lock()
+	rotateErr := w.rotate()
+	w.mu.Unlock()
+	if rotateErr != nil {
+		t.Fatalf("rotate: %v", rotateErr)
+	}
+	// second segment, written through write() so it isn't empty
+	if err := w.write(testEvents(1)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	segmentsBefore := len(w.segments)
+
+	wantErr := errors.New("endpoint still down")
+	err := w.replay(context.Background(), func(ctx context.Context, events []*collector.EventMsg) error {
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+	if len(w.segments) != segmentsBefore {
+		t.Fatalf("expected failed replay to requeue all %d segments, got %d", segmentsBefore, len(w.segments))
+	}
+}
+
+func TestWAL_RotatesAtSegmentSize(t *testing.T) {
+	w := newTestWAL(t, 0)
+	big := make([]*collector.EventMsg, 1)
+	big[0] = &collector.EventMsg{
+		Name:      "meas",
+		Values:    map[string]interface{}{"v": string(make([]byte, walSegmentMaxBytes))},
+		Timestamp: 1,
+	}
+	if err := w.write(big); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.write(testEvents(1)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if len(w.segments) != 2 {
+		t.Fatalf("expected a second write past walSegmentMaxBytes to rotate into a new segment, got %d segments", len(w.segments))
+	}
+}
+
+func TestWAL_EvictsOldestSegmentOverCap(t *testing.T) {
+	w := newTestWAL(t, 1) // tiny cap forces eviction on every write past the first
+	if err := w.write(testEvents(50)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	w.mu.Lock()
+	rotateErr := w.rotate()
+	w.mu.Unlock()
+	if rotateErr != nil {
+		t.Fatalf("rotate: %v", rotateErr)
+	}
+	oldest := w.segments[0]
+	if err := w.write(testEvents(50)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+		t.Fatalf("expected oldest segment %q to be evicted once the WAL exceeded maxBytes", oldest)
+	}
+	if len(w.segments) != 1 {
+		t.Fatalf("expected exactly the current segment to remain, got %d", len(w.segments))
+	}
+}
+
+func TestNewWAL_IndexesLeftoverSegments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1.wal"), []byte(`{"name":"meas","timestamp":1}`+"\n"), 0o644); err != nil {
+		t.Fatalf("seed leftover segment: %v", err)
+	}
+	w, err := newWAL(dir, 0, log.New(os.Stderr, "", 0))
+	if err != nil {
+		t.Fatalf("newWAL: %v", err)
+	}
+	if len(w.segments) != 1 {
+		t.Fatalf("expected newWAL to index the leftover segment from a previous run, got %d segments", len(w.segments))
+	}
+
+	var replayed int
+	err = w.replay(context.Background(), func(ctx context.Context, events []*collector.EventMsg) error {
+		replayed += len(events)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if replayed != 1 {
+		t.Fatalf("expected the leftover segment's event to be replayed, got %d", replayed)
+	}
+}
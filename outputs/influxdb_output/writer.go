@@ -0,0 +1,128 @@
+package influxdb_output
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	influxdb1client "github.com/influxdata/influxdb1-client/v2"
+	"github.com/karimra/gnmic/collector"
+)
+
+const (
+	versionV1 = "v1"
+	versionV2 = "v2"
+
+	defaultVersion   = versionV2
+	defaultPrecision = "ns"
+)
+
+// writer is the part of the InfluxDB client that differs between the v1
+// line-protocol API and the v2 client: how a batch is written, how liveness
+// is probed and how the client is torn down. writeBatch is synchronous so
+// the caller can retry it or spool the batch to the WAL on failure.
+// Everything else (batching across endpoints, health-check cadence, worker
+// selection, retry/WAL) is shared.
+type writer interface {
+	writeBatch(ctx context.Context, events []*collector.EventMsg) error
+	ping(ctx context.Context) error
+	close()
+}
+
+// newWriter builds the writer for a single endpoint according to Cfg.Version.
+// tlsCfg is nil unless Cfg.EnableTLS is set; iopts already carries it for the
+// v2 path, v1Writer needs it passed in separately since its HTTP client
+// isn't configured through influxdb2.Options.
+func newWriter(cfg *Config, url string, iopts *influxdb2.Options, tlsCfg *tls.Config) (writer, error) {
+	if cfg.Version == versionV1 {
+		return newV1Writer(cfg, url, tlsCfg)
+	}
+	client := influxdb2.NewClientWithOptions(url, cfg.Token, iopts)
+	return &v2Writer{
+		client: client,
+		api:    client.WriteAPIBlocking(cfg.Org, cfg.Bucket),
+	}, nil
+}
+
+// v2Writer writes synchronously through influxdb2's blocking write API.
+type v2Writer struct {
+	client influxdb2.Client
+	api    api.WriteAPIBlocking
+}
+
+func (w *v2Writer) writeBatch(ctx context.Context, events []*collector.EventMsg) error {
+	points := make([]*write.Point, len(events))
+	for idx, ev := range events {
+		points[idx] = influxdb2.NewPoint(ev.Name, ev.Tags, ev.Values, time.Unix(0, ev.Timestamp))
+	}
+	return w.api.WritePoint(ctx, points...)
+}
+
+func (w *v2Writer) ping(ctx context.Context) error {
+	_, err := w.client.Health(ctx)
+	return err
+}
+
+func (w *v2Writer) close() { w.client.Close() }
+
+// v1Writer talks line-protocol to an InfluxDB 1.x server.
+type v1Writer struct {
+	client      influxdb1client.Client
+	database    string
+	retention   string
+	precision   string
+	consistency string
+}
+
+func newV1Writer(cfg *Config, url string, tlsCfg *tls.Config) (*v1Writer, error) {
+	precision := cfg.Precision
+	if precision == "" {
+		precision = defaultPrecision
+	}
+	client, err := influxdb1client.NewHTTPClient(influxdb1client.HTTPConfig{
+		Addr:      url,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		TLSConfig: tlsCfg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v1Writer{
+		client:      client,
+		database:    cfg.Database,
+		retention:   cfg.RetentionPolicy,
+		precision:   precision,
+		consistency: cfg.Consistency,
+	}, nil
+}
+
+func (w *v1Writer) writeBatch(ctx context.Context, events []*collector.EventMsg) error {
+	bp, err := influxdb1client.NewBatchPoints(influxdb1client.BatchPointsConfig{
+		Database:         w.database,
+		RetentionPolicy:  w.retention,
+		Precision:        w.precision,
+		WriteConsistency: w.consistency,
+	})
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		pt, err := influxdb1client.NewPoint(ev.Name, ev.Tags, ev.Values, time.Unix(0, ev.Timestamp))
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(pt)
+	}
+	return w.client.Write(bp)
+}
+
+func (w *v1Writer) ping(ctx context.Context) error {
+	_, _, err := w.client.Ping(0)
+	return err
+}
+
+func (w *v1Writer) close() { w.client.Close() }